@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/paulsmith/aws-ssh/pkg/discover"
+)
+
+// sshHostKeyBlockRE matches the fingerprint block cloud-init prints to the
+// console on first boot, e.g.:
+//
+//	-----BEGIN SSH HOST KEY KEYS-----
+//	ssh-rsa AAAA... root@ip-10-0-0-1
+//	-----END SSH HOST KEY KEYS-----
+var sshHostKeyBlockRE = regexp.MustCompile(`(?s)-----BEGIN SSH HOST KEY KEYS-----\r?\n(.*?)-----END SSH HOST KEY KEYS-----`)
+
+// populateKnownHosts fetches SSH host public keys for each of instances and
+// appends any not already present to the known_hosts file at path. Each
+// instance is queried in its own Region; region (which may be a
+// comma-delimited list, matching -region) supplies the first entry as a
+// fallback for instances with no Region.
+func populateKnownHosts(path string, region string, bastionHost discover.Instance, instances []discover.Instance) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	seen, err := readKnownHostsLines(path)
+	if err != nil {
+		return err
+	}
+
+	var added int
+	for _, inst := range instances {
+		instRegion := inst.Region
+		if instRegion == "" {
+			instRegion = strings.TrimSpace(strings.Split(region, ",")[0])
+		}
+		cfg := aws.NewConfig().WithRegion(instRegion)
+		lines, err := fetchHostKeyLines(ec2.New(sess, cfg), ssm.New(sess, cfg), bastionHost, inst)
+		if err != nil {
+			log.Printf("known_hosts: %s: %v", inst.Name, err)
+			continue
+		}
+		for _, line := range lines {
+			if seen[line] {
+				continue
+			}
+			seen[line] = true
+			if err := appendKnownHostsLine(path, line); err != nil {
+				return err
+			}
+			added++
+		}
+	}
+	log.Printf("known_hosts: added %d new host key line(s) to %s", added, path)
+	return nil
+}
+
+// fetchHostKeyLines tries, in order, to obtain host keys for inst from the
+// EC2 console output, over SSM, and finally by completing a handshake
+// through the bastion and capturing the key offered.
+func fetchHostKeyLines(ec2Svc *ec2.EC2, ssmSvc *ssm.SSM, bastionHost, inst discover.Instance) ([]string, error) {
+	if keys, err := fetchKeysFromConsoleOutput(ec2Svc, inst); err == nil {
+		return formatKnownHostsLines(inst, keys), nil
+	}
+
+	if keys, err := fetchKeysFromSSM(ssmSvc, inst); err == nil {
+		return formatKnownHostsLines(inst, keys), nil
+	}
+
+	keys, err := fetchKeysFromHandshake(bastionHost, inst)
+	if err != nil {
+		return nil, fmt.Errorf("no host keys found via console output, SSM, or handshake: %w", err)
+	}
+	return formatKnownHostsLines(inst, keys), nil
+}
+
+func fetchKeysFromConsoleOutput(svc *ec2.EC2, inst discover.Instance) ([]string, error) {
+	out, err := svc.GetConsoleOutput(&ec2.GetConsoleOutputInput{InstanceId: aws.String(inst.ID)})
+	if err != nil {
+		return nil, err
+	}
+	if out.Output == nil {
+		return nil, fmt.Errorf("no console output available yet")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(*out.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	m := sshHostKeyBlockRE.FindSubmatch(decoded)
+	if m == nil {
+		return nil, fmt.Errorf("console output has no cloud-init SSH host key block")
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(m[1]), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	return keys, nil
+}
+
+func fetchKeysFromSSM(svc *ssm.SSM, inst discover.Instance) ([]string, error) {
+	cmd, err := svc.SendCommand(&ssm.SendCommandInput{
+		InstanceIds:  []*string{aws.String(inst.ID)},
+		DocumentName: aws.String("AWS-RunShellScript"),
+		Parameters: map[string][]*string{
+			"commands": {aws.String("cat /etc/ssh/ssh_host_*_key.pub")},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	commandID := *cmd.Command.CommandId
+
+	var invocation *ssm.GetCommandInvocationOutput
+	for i := 0; i < 15; i++ {
+		invocation, err = svc.GetCommandInvocation(&ssm.GetCommandInvocationInput{
+			CommandId:  aws.String(commandID),
+			InstanceId: aws.String(inst.ID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch *invocation.Status {
+		case ssm.CommandInvocationStatusPending, ssm.CommandInvocationStatusInProgress:
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		break
+	}
+	if invocation == nil || *invocation.Status != ssm.CommandInvocationStatusSuccess {
+		return nil, fmt.Errorf("no SSM agent available or command did not succeed")
+	}
+
+	var keys []string
+	for _, line := range strings.Split(*invocation.StandardOutputContent, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	return keys, nil
+}
+
+// fetchKeysFromHandshake is the last-resort path: dial the bastion, tunnel a
+// raw TCP connection to inst the same way ProxyCommand would, and capture
+// whatever host key is offered during the handshake. The handshake is always
+// aborted once a key is captured; no credentials are ever sent to inst.
+// Requires an actual bastion and a running ssh-agent holding a key the
+// bastion accepts; without either, this path is skipped rather than
+// attempted and failed.
+func fetchKeysFromHandshake(bastionHost, inst discover.Instance) ([]string, error) {
+	if bastionHost.PublicDNSName == "" {
+		return nil, fmt.Errorf("no bastion available for the handshake fallback")
+	}
+
+	auth, closeAgent, err := sshAgentAuth()
+	if err != nil {
+		return nil, fmt.Errorf("handshake fallback: %w", err)
+	}
+	defer closeAgent()
+
+	bastionConn, err := ssh.Dial("tcp", bastionHost.PublicDNSName+":22", &ssh.ClientConfig{
+		User:            "ec2-user",
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dialing bastion: %w", err)
+	}
+	defer bastionConn.Close()
+
+	addr := net.JoinHostPort(inst.PrivateIPAddr, "22")
+	conn, err := bastionConn.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s through bastion: %w", addr, err)
+	}
+	defer conn.Close()
+
+	var keys []string
+	captured := fmt.Errorf("aws-ssh: host key captured, aborting handshake")
+	_, _, _, err = ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User: "ec2-user",
+		Auth: []ssh.AuthMethod{auth},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			keys = append(keys, fmt.Sprintf("%s %s", key.Type(), base64.StdEncoding.EncodeToString(key.Marshal())))
+			return captured
+		},
+		Timeout: 5 * time.Second,
+	})
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("handshake did not yield a host key: %w", err)
+	}
+	return keys, nil
+}
+
+// sshAgentAuth returns an AuthMethod backed by the running ssh-agent at
+// SSH_AUTH_SOCK, along with a func to close the connection to it once the
+// handshake it's used for is done.
+func sshAgentAuth() (ssh.AuthMethod, func(), error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, fmt.Errorf("SSH_AUTH_SOCK not set, no ssh-agent to authenticate with")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dialing ssh-agent: %w", err)
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), func() { conn.Close() }, nil
+}
+
+func formatKnownHostsLines(inst discover.Instance, keys []string) []string {
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s,%s %s", inst.Name, inst.PrivateIPAddr, key))
+	}
+	return lines
+}
+
+func readKnownHostsLines(path string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return seen, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		seen[scanner.Text()] = true
+	}
+	return seen, scanner.Err()
+}
+
+func appendKnownHostsLine(path, line string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
@@ -2,17 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/paulsmith/aws-ssh/pkg/cache"
+	"github.com/paulsmith/aws-ssh/pkg/discover"
+	"github.com/paulsmith/aws-ssh/pkg/options"
+	"github.com/paulsmith/aws-ssh/pkg/policy"
 )
 
 var (
@@ -22,10 +28,26 @@ var (
 	env       = flag.String("e", "dev", fmt.Sprintf("environment - one of: %s", strings.Join(allowedEnvironments, ", ")))
 	role      = flag.String("r", "", fmt.Sprintf("role - one of: %s", strings.Join(allowedRoles, ", ")))
 	suffix    = flag.String("s", "", "string to suffix to host name")
-	region    = flag.String("region", "us-east-1", "AWS region")
+	region    = flag.String("region", "us-east-1", "AWS region, or a comma-delimited list to discover across multiple regions")
 	skipRoles = flag.String("skiproles", "nat", "roles to skip")
+	backend   = flag.String("backend", "ec2://", "discovery backend location (ec2://, static:///path/to/hosts.yaml, gcp://, azure://, tailscale://<tailnet>)")
+	proxy     = flag.String("proxy", "bastion", "how to reach instances on private subnets: bastion (jump host) or ssm (AWS SSM Session Manager)")
+
+	knownHosts     = flag.Bool("known-hosts", false, "fetch instance SSH host keys and populate a known_hosts file")
+	knownHostsFile = flag.String("known-hosts-file", "~/.ssh/known_hosts", "known_hosts file to populate when -known-hosts is set")
+
+	policyFile = flag.String("policy", "", "path to a policy file (YAML or JSON) controlling which instances are emitted, as which user, and via which bastion; defaults to -skiproles behavior")
+
+	cacheFile = flag.String("cache", "", "path to a discovery cache file; when set, repeated runs within -cache-ttl skip re-querying the backend")
+	cacheTTL  = flag.Duration("cache-ttl", 10*time.Minute, "how long a -cache entry stays valid")
+
+	opts = make(options.Options)
 )
 
+func init() {
+	flag.Var(options.Flag{Options: opts}, "o", "extended backend option in key=value form (repeatable), e.g. -o ec2.profile=prod")
+}
+
 func usage() {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "Outputs fragments of SSH config file of AWS instances\n\n")
@@ -34,8 +56,16 @@ func usage() {
 	fmt.Fprintf(&buf, "-e           environment\n")
 	fmt.Fprintf(&buf, "-r           role\n")
 	fmt.Fprintf(&buf, "-s           suffix to append to host name\n")
-	fmt.Fprintf(&buf, "-region      AWS region (default: us-east-1)\n")
+	fmt.Fprintf(&buf, "-region      AWS region, or comma-delimited list (default: us-east-1)\n")
 	fmt.Fprintf(&buf, "-skiproles   comma-delimited roles to skip (default: nat)\n")
+	fmt.Fprintf(&buf, "-backend     discovery backend location (default: ec2://)\n")
+	fmt.Fprintf(&buf, "-proxy       bastion (default) or ssm, how to reach instances on private subnets\n")
+	fmt.Fprintf(&buf, "-o           extended backend option in key=value form, repeatable (e.g. -o ec2.profile=prod)\n")
+	fmt.Fprintf(&buf, "-known-hosts fetch instance SSH host keys and populate a known_hosts file\n")
+	fmt.Fprintf(&buf, "-known-hosts-file  known_hosts file to populate (default: ~/.ssh/known_hosts)\n")
+	fmt.Fprintf(&buf, "-policy      path to a policy file controlling emission, user, and bastion (default: derived from -skiproles)\n")
+	fmt.Fprintf(&buf, "-cache       path to a discovery cache file (default: disabled)\n")
+	fmt.Fprintf(&buf, "-cache-ttl   how long a -cache entry stays valid (default: 10m)\n")
 	io.Copy(os.Stderr, &buf)
 }
 
@@ -46,155 +76,282 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	instances, err := getInstances(*region, *env, "bastion", nil)
+	if _, ok := opts["ec2.region"]; !ok {
+		opts["ec2.region"] = *region
+	}
+
+	if *proxy != "bastion" && *proxy != "ssm" {
+		log.Fatalf("unknown -proxy %q, expected bastion or ssm", *proxy)
+	}
+
+	d, err := discover.Open(*backend, opts)
 	if err != nil {
 		panic(err)
 	}
 
-	if len(instances) != 1 {
-		log.Fatalf("expected 1 bastion host instance in the %s environment, found %d", *env, len(instances))
+	var dc *cache.Cache
+	if *cacheFile != "" {
+		dc, err = cache.Open(expandUser(*cacheFile), *cacheTTL)
+		if err != nil {
+			panic(err)
+		}
 	}
-	bastionHost := instances[0]
 
-	rolesToSkip := strings.Split(*skipRoles, ",")
+	ctx := context.Background()
+
+	optsKey := cacheOptsKey(opts)
+
+	discoverCached := func(filter discover.Filter) ([]discover.Instance, error) {
+		key := cache.Key{Backend: *backend, Opts: optsKey, Env: filter.Env, Role: filter.Role, SkipRoles: *skipRoles}
+		if dc != nil {
+			if cached, ok := dc.Get(key); ok {
+				if _, stillValid := revalidate(ctx, d, cached); stillValid {
+					return cached, nil
+				}
+				log.Printf("cache: instance state changed, discarding cache hit")
+			}
+		}
+
+		instances, err := d.Discover(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if dc != nil {
+			if err := dc.Put(key, instances); err != nil {
+				log.Printf("cache: %v", err)
+			}
+		}
+		return instances, nil
+	}
+
+	var defaultBastion discover.Instance
+	bastionsBySelector := make(map[string]discover.Instance)
+	if *proxy == "bastion" {
+		found, err := discoverCached(discover.Filter{Env: *env, Role: "bastion"})
+		if err != nil {
+			panic(err)
+		}
+		if len(found) == 0 {
+			log.Fatalf("expected at least 1 bastion host instance in the %s environment, found 0", *env)
+		}
+		defaultBastion = found[0]
+
+		bastionTmpl := template.Must(template.New("bastion").Parse(bastion))
+		for _, b := range found {
+			bastionsBySelector[bastionSelector(b)] = b
+
+			if err := bastionTmpl.Execute(os.Stdout, struct {
+				Host          string
+				PublicDnsName string
+				PathToKey     string
+				User          string
+			}{
+				b.Name,
+				b.PublicDNSName,
+				"~/.ssh/" + b.KeyName + ".pem",
+				bastionUser,
+			}); err != nil {
+				panic(err)
+			}
+		}
+	}
 
-	instances, err = getInstances(*region, *env, *role, rolesToSkip)
+	instances, err := discoverCached(discover.Filter{Env: *env, Role: *role})
 	if err != nil {
 		panic(err)
 	}
 
-	bastionTmpl := template.Must(template.New("bastion").Parse(bastion))
-	if err := bastionTmpl.Execute(os.Stdout, struct {
-		Host          string
-		PublicDnsName string
-		PathToKey     string
-		User          string
-	}{
-		bastionHost.name,
-		bastionHost.publicDnsName,
-		"~/.ssh/" + bastionHost.keyName + ".pem",
-		"ec2-user",
-	}); err != nil {
+	if *proxy == "ssm" {
+		instances, err = filterSSMOnline(*region, instances)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	pol, err := loadPolicy(*policyFile, strings.Split(*skipRoles, ","))
+	if err != nil {
 		panic(err)
 	}
+	principal := currentUser()
 
-	tmpl := template.Must(template.New("host").Parse(host))
+	hostTmplSrc := host
+	if *proxy == "ssm" {
+		hostTmplSrc = ssmHost
+	}
+	tmpl := template.Must(template.New("host").Parse(hostTmplSrc))
 
 	for _, inst := range instances {
-		if err := tmpl.Execute(os.Stdout, struct {
-			Host          string
-			PathToKey     string
-			PrivateIpAddr string
-			User          string
-			BastionHost   string
-		}{
-			inst.name + *suffix,
-			"~/.ssh/" + inst.keyName + ".pem",
-			inst.privateIpAddr,
-			"ec2-user",
-			bastionHost.name,
-		}); err != nil {
-			panic(err)
+		decision := pol.Evaluate(principal, policy.Instance{
+			Name:   inst.Name,
+			Region: inst.Region,
+			VPCID:  inst.VPCID,
+			Tags:   inst.Tags,
+		})
+		if !decision.Allow {
+			continue
 		}
-	}
-}
 
-var bastion = `
-Host {{.Host}}
-    Hostname {{.PublicDnsName}}
-    IdentityFile {{.PathToKey}}
-    ForwardAgent yes
-    User {{.User}}
-    StrictHostKeyChecking no
-`
+		bastionTarget := defaultBastion
+		if sel := decision.BastionSelector; sel != "" && sel != "bastion" {
+			b, ok := bastionsBySelector[sel]
+			if !ok {
+				log.Printf("policy: %s: no bastion matches selector %q, skipping", inst.Name, sel)
+				continue
+			}
+			bastionTarget = b
+		}
 
-var host = `
-Host {{.Host}}
-    IdentityFile {{.PathToKey}}
-    Hostname {{.PrivateIpAddr}}
-    User {{.User}}
-    StrictHostKeyChecking no
-    ProxyCommand ssh {{.User}}@{{.BastionHost}} -W %h:%p
-`
+		var execErr error
+		if *proxy == "ssm" {
+			execErr = tmpl.Execute(os.Stdout, struct {
+				Host       string
+				PathToKey  string
+				User       string
+				InstanceId string
+				Region     string
+				Profile    string
+			}{
+				inst.Name + *suffix,
+				"~/.ssh/" + inst.KeyName + ".pem",
+				decision.User,
+				inst.ID,
+				inst.Region,
+				opts.Get("ec2.profile", ""),
+			})
+		} else {
+			execErr = tmpl.Execute(os.Stdout, struct {
+				Host          string
+				PathToKey     string
+				PrivateIpAddr string
+				User          string
+				BastionUser   string
+				BastionHost   string
+			}{
+				inst.Name + *suffix,
+				"~/.ssh/" + inst.KeyName + ".pem",
+				inst.PrivateIPAddr,
+				decision.User,
+				bastionUser,
+				bastionTarget.Name,
+			})
+		}
+		if execErr != nil {
+			panic(execErr)
+		}
+	}
 
-type instance struct {
-	id            string
-	name          string
-	publicDnsName string
-	privateIpAddr string
-	keyName       string
+	if *knownHosts {
+		path := expandUser(*knownHostsFile)
+		all := instances
+		if *proxy == "bastion" {
+			all = append([]discover.Instance{defaultBastion}, instances...)
+		}
+		if err := populateKnownHosts(path, *region, defaultBastion, all); err != nil {
+			panic(err)
+		}
+	}
 }
 
-func getInstances(region string, env string, role string, rolesToSkip []string) ([]instance, error) {
-	svc := ec2.New(&aws.Config{Region: aws.String(region)})
+// bastionUser is the login user aws-ssh uses for every bastion, independent
+// of whichever SSH user a policy rule assigns the instance being jumped to.
+const bastionUser = "ec2-user"
 
-	params := &ec2.DescribeInstancesInput{}
-	if env != "" {
-		params.Filters = append(params.Filters, &ec2.Filter{
-			Name:   aws.String("tag:env"),
-			Values: []*string{aws.String(env)},
-		})
+// bastionSelector is the name a "jump-through:<selector>" rule action
+// addresses this bastion by: its EC2 Name tag, so selectors survive the
+// per-environment "bastion-<env>" display name override in pkg/discover's
+// EC2 backend, falling back to that display name if the instance has no
+// Name tag.
+func bastionSelector(b discover.Instance) string {
+	if name := b.Tags["Name"]; name != "" {
+		return name
 	}
-	if role != "" {
-		params.Filters = append(params.Filters, &ec2.Filter{
-			Name:   aws.String("tag:role"),
-			Values: []*string{aws.String(role)},
-		})
+	return b.Name
+}
+
+// revalidate cheaply re-checks a cache hit's instances via the Discoverer's
+// optional StateChecker and reports whether the hit is still valid, i.e. no
+// instance's state or launch time has moved since it was cached. Discoverers
+// that don't implement StateChecker are trusted for the full TTL.
+func revalidate(ctx context.Context, d discover.Discoverer, cached []discover.Instance) ([]discover.Instance, bool) {
+	checker, ok := d.(discover.StateChecker)
+	if !ok {
+		return nil, true
 	}
 
-	resp, err := svc.DescribeInstances(params)
+	fresh, err := checker.CheckState(ctx, cached)
 	if err != nil {
-		return nil, err
+		log.Printf("cache: checking instance state: %v", err)
+		return nil, true
 	}
 
-	log.Printf("Found %d reservation(s)", len(resp.Reservations))
-
-	var instances []instance
+	return fresh, !cache.Transitioned(cached, fresh)
+}
 
-	for _, res := range resp.Reservations {
-		log.Printf("Found %d instance(s) in the reservation", len(res.Instances))
+// cacheOptsKey deterministically encodes opts (including any backend region
+// override such as ec2.region) for use in a cache.Key, so two runs with
+// different -o settings never collide on the same cache entry.
+func cacheOptsKey(opts options.Options) string {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-		for _, inst := range res.Instances {
-			if *inst.State.Name != "running" {
-				continue
-			}
-			var name string
-			for _, tag := range inst.Tags {
-				if *tag.Key == "role" {
-					role := *tag.Value
-					if in(role, rolesToSkip) {
-						continue
-					}
-				}
-				if *tag.Key == "Name" {
-					name = *tag.Value
-				}
-			}
-			if name == "" {
-				name = *inst.InstanceId
-			}
-			if role == "bastion" {
-				name = "bastion-" + env
-			}
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + opts[k]
+	}
+	return strings.Join(parts, ",")
+}
 
-			instances = append(instances, instance{
-				id:            *inst.InstanceId,
-				name:          name,
-				publicDnsName: *inst.PublicDnsName,
-				privateIpAddr: *inst.PrivateIpAddress,
-				keyName:       *inst.KeyName,
-			})
-		}
+func loadPolicy(path string, rolesToSkip []string) (*policy.Policy, error) {
+	if path == "" {
+		return policy.Default(rolesToSkip), nil
 	}
+	return policy.Load(path)
+}
 
-	return instances, err
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
 }
 
-func in(needle string, haystack []string) bool {
-	for i := range haystack {
-		if needle == haystack[i] {
-			return true
-		}
+func expandUser(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
 	}
-	return false
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
 }
+
+var bastion = `
+Host {{.Host}}
+    Hostname {{.PublicDnsName}}
+    IdentityFile {{.PathToKey}}
+    ForwardAgent yes
+    User {{.User}}
+    StrictHostKeyChecking no
+`
+
+var host = `
+Host {{.Host}}
+    IdentityFile {{.PathToKey}}
+    Hostname {{.PrivateIpAddr}}
+    User {{.User}}
+    StrictHostKeyChecking no
+    ProxyCommand ssh {{.BastionUser}}@{{.BastionHost}} -W %h:%p
+`
+
+var ssmHost = `
+Host {{.Host}}
+    IdentityFile {{.PathToKey}}
+    User {{.User}}
+    StrictHostKeyChecking no
+    ProxyCommand aws ssm start-session --target {{.InstanceId}} --document-name AWS-StartSSHSession --parameters portNumber=%p --region {{.Region}}{{if .Profile}} --profile {{.Profile}}{{end}}
+`
@@ -0,0 +1,128 @@
+// Package cache persists discovered instance lists to a local JSON file so
+// repeated aws-ssh invocations during a workday don't re-hit the backend's
+// discovery API.
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/paulsmith/aws-ssh/pkg/discover"
+)
+
+// Key identifies a cached discovery result. Backend and Opts should capture
+// everything that affects what a Discoverer returns (location URI and
+// extended options, e.g. "ec2.region") — not just the -region flag, since a
+// backend can resolve its region from its location host or an -o override
+// instead.
+type Key struct {
+	Backend   string
+	Opts      string
+	Env       string
+	Role      string
+	SkipRoles string
+}
+
+func (k Key) string() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", k.Backend, k.Opts, k.Env, k.Role, k.SkipRoles)
+}
+
+type entry struct {
+	StoredAt  time.Time           `json:"stored_at"`
+	Instances []discover.Instance `json:"instances"`
+}
+
+// Cache is a TTL'd, file-backed store of discovery results keyed by Key.
+type Cache struct {
+	path    string
+	ttl     time.Duration
+	entries map[string]entry
+}
+
+// Open loads the cache file at path, if it exists; a missing file starts
+// an empty cache rather than erroring.
+func Open(path string, ttl time.Duration) (*Cache, error) {
+	c := &Cache{path: path, ttl: ttl, entries: map[string]entry{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("cache: parsing %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Get returns the instances cached under key, if the entry exists and is
+// younger than the cache's TTL. Get alone doesn't check whether a cached
+// instance's state or launch time has since moved; a caller wanting that
+// invalidated mid-TTL should pair a hit with a cheap re-check (see
+// discover.StateChecker) and Transitioned, as main.go's discoverCached does.
+func (c *Cache) Get(key Key) ([]discover.Instance, bool) {
+	e, ok := c.entries[key.string()]
+	if !ok || time.Since(e.StoredAt) > c.ttl {
+		return nil, false
+	}
+	return e.Instances, true
+}
+
+// Put stores instances under key and writes the cache file to disk,
+// logging any instance whose state or launch time changed since the last
+// time this key was cached.
+func (c *Cache) Put(key Key, instances []discover.Instance) error {
+	if prev, ok := c.entries[key.string()]; ok {
+		Transitioned(prev.Instances, instances)
+	}
+
+	c.entries[key.string()] = entry{StoredAt: time.Now(), Instances: instances}
+	return c.save()
+}
+
+// Transitioned reports whether any instance in after has a different State
+// or LaunchTime than its counterpart in before (matched by ID), logging
+// each transition it finds. Put calls this purely as a diagnostic once a
+// fresh discovery has already run; discoverCached also calls it against a
+// StateChecker's cheap re-check to decide whether to treat a still-fresh
+// cache hit as invalidated.
+func Transitioned(before, after []discover.Instance) bool {
+	prev := make(map[string]discover.Instance, len(before))
+	for _, inst := range before {
+		prev[inst.ID] = inst
+	}
+
+	var changed bool
+	for _, inst := range after {
+		old, ok := prev[inst.ID]
+		if !ok {
+			continue
+		}
+		if old.State != inst.State {
+			log.Printf("cache: %s transitioned %s -> %s", inst.Name, old.State, inst.State)
+			changed = true
+		}
+		if old.LaunchTime != nil && inst.LaunchTime != nil && !old.LaunchTime.Equal(*inst.LaunchTime) {
+			log.Printf("cache: %s was relaunched", inst.Name)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (c *Cache) save() error {
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0600)
+}
@@ -0,0 +1,26 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/paulsmith/aws-ssh/pkg/options"
+)
+
+func init() {
+	Register("azure", newAzureDiscoverer)
+}
+
+type azureDiscoverer struct{}
+
+// newAzureDiscoverer is registered so "azure://" locations fail with a
+// clear "not implemented" error rather than "unknown scheme"; Azure VM
+// discovery is not wired up yet.
+func newAzureDiscoverer(loc *url.URL, opts options.Options) (Discoverer, error) {
+	return &azureDiscoverer{}, nil
+}
+
+func (d *azureDiscoverer) Discover(ctx context.Context, filter Filter) ([]Instance, error) {
+	return nil, fmt.Errorf("azure discovery backend is not yet implemented")
+}
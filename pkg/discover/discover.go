@@ -0,0 +1,83 @@
+// Package discover defines the pluggable host discovery backends aws-ssh
+// draws instances from, selected by a restic-style location URI (ec2://,
+// static://path/to/hosts.yaml, ...).
+package discover
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/paulsmith/aws-ssh/pkg/options"
+)
+
+// Instance is a host discovered by a Discoverer, in a form the rendering
+// pipeline and policy engine can consume regardless of backend.
+type Instance struct {
+	ID            string            `json:"id,omitempty" yaml:"id,omitempty"`
+	Name          string            `json:"name" yaml:"name"`
+	Region        string            `json:"region,omitempty" yaml:"region,omitempty"`
+	VPCID         string            `json:"vpc_id,omitempty" yaml:"vpc_id,omitempty"`
+	PublicDNSName string            `json:"public_dns_name,omitempty" yaml:"public_dns_name,omitempty"`
+	PrivateIPAddr string            `json:"private_ip_addr" yaml:"private_ip_addr"`
+	KeyName       string            `json:"key_name,omitempty" yaml:"key_name,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+
+	// State and LaunchTime aren't used for rendering; pkg/cache compares
+	// them across refreshes and logs an instance that has changed state or
+	// been replaced, and (via StateChecker) can invalidate a cache hit
+	// mid-TTL when one has.
+	State      string     `json:"state,omitempty" yaml:"state,omitempty"`
+	LaunchTime *time.Time `json:"launch_time,omitempty" yaml:"launch_time,omitempty"`
+}
+
+// Filter narrows discovery to instances matching an environment and/or
+// role. Backends interpret these however makes sense for their source of
+// truth (EC2 tags, a static file's fields, ...).
+type Filter struct {
+	Env  string
+	Role string
+}
+
+// Discoverer finds instances from a backend.
+type Discoverer interface {
+	Discover(ctx context.Context, filter Filter) ([]Instance, error)
+}
+
+// StateChecker is optionally implemented by a Discoverer that can cheaply
+// re-check the State and LaunchTime of already-discovered instances (by ID)
+// without re-running a full, tag-filtered Discover. pkg/cache's caller uses
+// this to invalidate a cache hit whose instances have since changed state,
+// rather than only ever expiring on TTL.
+type StateChecker interface {
+	CheckState(ctx context.Context, instances []Instance) ([]Instance, error)
+}
+
+// Factory constructs a Discoverer for a parsed location URI, consuming its
+// own namespaced options (e.g. "ec2.profile").
+type Factory func(loc *url.URL, opts options.Options) (Discoverer, error)
+
+var registry = map[string]Factory{}
+
+// Register adds a backend factory under scheme, e.g. "ec2". Backends call
+// this from an init function.
+func Register(scheme string, factory Factory) {
+	registry[scheme] = factory
+}
+
+// Open parses location as a URI (e.g. "ec2://", "static:///path/to/hosts.yaml")
+// and returns the Discoverer for its scheme, configured from opts's
+// scheme-namespaced options.
+func Open(location string, opts options.Options) (Discoverer, error) {
+	loc, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parsing discovery location %q: %w", location, err)
+	}
+
+	factory, ok := registry[loc.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("no discovery backend registered for scheme %q", loc.Scheme)
+	}
+	return factory(loc, opts.Namespace(loc.Scheme))
+}
@@ -0,0 +1,215 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/paulsmith/aws-ssh/pkg/options"
+)
+
+const defaultRegionParallelism = 8
+
+func init() {
+	Register("ec2", newEC2Discoverer)
+}
+
+type ec2Discoverer struct {
+	sess        *session.Session
+	cfg         *aws.Config
+	regions     []string
+	parallelism int
+}
+
+// newEC2Discoverer builds an EC2-backed Discoverer. Recognized options:
+// region (comma-delimited for multi-region discovery), profile,
+// assume-role, mfa-serial, session-tag, parallelism.
+func newEC2Discoverer(loc *url.URL, opts options.Options) (Discoverer, error) {
+	regionOpt := loc.Host
+	if regionOpt == "" {
+		regionOpt = opts.Get("region", "us-east-1")
+	}
+
+	var regions []string
+	for _, r := range strings.Split(regionOpt, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			regions = append(regions, r)
+		}
+	}
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("ec2: no region given")
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           opts.Get("profile", ""),
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ec2: creating session: %w", err)
+	}
+
+	cfg := aws.NewConfig()
+	if roleARN := opts.Get("assume-role", ""); roleARN != "" {
+		cfg = cfg.WithCredentials(stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+			if serial := opts.Get("mfa-serial", ""); serial != "" {
+				p.SerialNumber = aws.String(serial)
+			}
+			if tag := opts.Get("session-tag", ""); tag != "" {
+				p.Tags = []*sts.Tag{{Key: aws.String("aws-ssh"), Value: aws.String(tag)}}
+			}
+		}))
+	}
+
+	parallelism := defaultRegionParallelism
+	if n, err := strconv.Atoi(opts.Get("parallelism", "")); err == nil && n > 0 {
+		parallelism = n
+	}
+
+	return &ec2Discoverer{sess: sess, cfg: cfg, regions: regions, parallelism: parallelism}, nil
+}
+
+func (d *ec2Discoverer) Discover(ctx context.Context, filter Filter) ([]Instance, error) {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(d.parallelism)
+
+	var mu sync.Mutex
+	var all []Instance
+
+	for _, region := range d.regions {
+		region := region
+		g.Go(func() error {
+			instances, err := d.discoverRegion(ctx, region, filter)
+			if err != nil {
+				return fmt.Errorf("ec2: %s: %w", region, err)
+			}
+			mu.Lock()
+			all = append(all, instances...)
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Region != all[j].Region {
+			return all[i].Region < all[j].Region
+		}
+		return all[i].Name < all[j].Name
+	})
+
+	return all, nil
+}
+
+func (d *ec2Discoverer) discoverRegion(ctx context.Context, region string, filter Filter) ([]Instance, error) {
+	svc := ec2.New(d.sess, d.cfg.Copy().WithRegion(region))
+
+	params := &ec2.DescribeInstancesInput{}
+	if filter.Env != "" {
+		params.Filters = append(params.Filters, &ec2.Filter{
+			Name:   aws.String("tag:env"),
+			Values: []*string{aws.String(filter.Env)},
+		})
+	}
+	if filter.Role != "" {
+		params.Filters = append(params.Filters, &ec2.Filter{
+			Name:   aws.String("tag:role"),
+			Values: []*string{aws.String(filter.Role)},
+		})
+	}
+
+	var instances []Instance
+	err := svc.DescribeInstancesPagesWithContext(ctx, params, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, res := range page.Reservations {
+			for _, inst := range res.Instances {
+				if *inst.State.Name != "running" {
+					continue
+				}
+
+				tags := make(map[string]string, len(inst.Tags))
+				for _, tag := range inst.Tags {
+					tags[*tag.Key] = *tag.Value
+				}
+
+				name := tags["Name"]
+				if name == "" {
+					name = *inst.InstanceId
+				}
+				if tags["role"] == "bastion" {
+					name = "bastion-" + filter.Env
+				}
+
+				instances = append(instances, Instance{
+					ID:            *inst.InstanceId,
+					Name:          name,
+					Region:        region,
+					VPCID:         aws.StringValue(inst.VpcId),
+					PublicDNSName: aws.StringValue(inst.PublicDnsName),
+					PrivateIPAddr: aws.StringValue(inst.PrivateIpAddress),
+					KeyName:       aws.StringValue(inst.KeyName),
+					Tags:          tags,
+					State:         aws.StringValue(inst.State.Name),
+					LaunchTime:    inst.LaunchTime,
+				})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing instances: %w", err)
+	}
+
+	return instances, nil
+}
+
+// CheckState implements discover.StateChecker by looking up just the given
+// instance IDs' current State and LaunchTime, grouped by region, with no tag
+// filters or pagination — much cheaper than a full Discover, so a cache hit
+// can be revalidated without paying for one.
+func (d *ec2Discoverer) CheckState(ctx context.Context, instances []Instance) ([]Instance, error) {
+	byRegion := make(map[string][]*string)
+	names := make(map[string]string, len(instances))
+	for _, inst := range instances {
+		if inst.ID == "" {
+			continue
+		}
+		byRegion[inst.Region] = append(byRegion[inst.Region], aws.String(inst.ID))
+		names[inst.ID] = inst.Name
+	}
+
+	var refreshed []Instance
+	for region, ids := range byRegion {
+		svc := ec2.New(d.sess, d.cfg.Copy().WithRegion(region))
+
+		out, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids})
+		if err != nil {
+			return nil, fmt.Errorf("checking instance state in %s: %w", region, err)
+		}
+		for _, res := range out.Reservations {
+			for _, inst := range res.Instances {
+				refreshed = append(refreshed, Instance{
+					ID:         *inst.InstanceId,
+					Name:       names[*inst.InstanceId],
+					Region:     region,
+					State:      aws.StringValue(inst.State.Name),
+					LaunchTime: inst.LaunchTime,
+				})
+			}
+		}
+	}
+
+	return refreshed, nil
+}
@@ -0,0 +1,26 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/paulsmith/aws-ssh/pkg/options"
+)
+
+func init() {
+	Register("gcp", newGCPDiscoverer)
+}
+
+type gcpDiscoverer struct{}
+
+// newGCPDiscoverer is registered so "gcp://" locations fail with a clear
+// "not implemented" error rather than "unknown scheme"; GCE instance
+// discovery is not wired up yet.
+func newGCPDiscoverer(loc *url.URL, opts options.Options) (Discoverer, error) {
+	return &gcpDiscoverer{}, nil
+}
+
+func (d *gcpDiscoverer) Discover(ctx context.Context, filter Filter) ([]Instance, error) {
+	return nil, fmt.Errorf("gcp discovery backend is not yet implemented")
+}
@@ -0,0 +1,77 @@
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/paulsmith/aws-ssh/pkg/options"
+)
+
+func init() {
+	Register("static", newStaticDiscoverer)
+}
+
+// staticDiscoverer serves a fixed instance list loaded once from a YAML or
+// JSON file, for fleets that aren't discoverable through a cloud API.
+type staticDiscoverer struct {
+	instances []Instance
+}
+
+// newStaticDiscoverer reads the file named by the location's path into a
+// list of Instance. Both "static:///abs/path/hosts.yaml" (path is
+// everything after the third slash) and the relative "static://hosts.yaml"
+// and "static://path/to/hosts.yaml" forms (url.Parse takes the first
+// segment as Host) are accepted; the "file" option covers "static://" with
+// no path at all.
+func newStaticDiscoverer(loc *url.URL, opts options.Options) (Discoverer, error) {
+	path := loc.Path
+	if loc.Host != "" {
+		path = loc.Host + loc.Path
+	}
+	if path == "" {
+		path = loc.Opaque
+	}
+	if path == "" {
+		path = opts.Get("file", "")
+	}
+	if path == "" {
+		return nil, fmt.Errorf("static: no file given, e.g. static:///path/to/hosts.yaml or -o static.file=hosts.yaml")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("static: %w", err)
+	}
+
+	var instances []Instance
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(data, &instances)
+	} else {
+		err = yaml.Unmarshal(data, &instances)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("static: parsing %s: %w", path, err)
+	}
+
+	return &staticDiscoverer{instances: instances}, nil
+}
+
+func (d *staticDiscoverer) Discover(ctx context.Context, filter Filter) ([]Instance, error) {
+	var matched []Instance
+	for _, inst := range d.instances {
+		if filter.Env != "" && inst.Tags["env"] != filter.Env {
+			continue
+		}
+		if filter.Role != "" && inst.Tags["role"] != filter.Role {
+			continue
+		}
+		matched = append(matched, inst)
+	}
+	return matched, nil
+}
@@ -0,0 +1,28 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/paulsmith/aws-ssh/pkg/options"
+)
+
+func init() {
+	Register("tailscale", newTailscaleDiscoverer)
+}
+
+type tailscaleDiscoverer struct {
+	tailnet string
+}
+
+// newTailscaleDiscoverer is registered so "tailscale://<tailnet>" locations
+// fail with a clear "not implemented" error rather than "unknown scheme";
+// it doesn't talk to the Tailscale API yet.
+func newTailscaleDiscoverer(loc *url.URL, opts options.Options) (Discoverer, error) {
+	return &tailscaleDiscoverer{tailnet: loc.Host}, nil
+}
+
+func (d *tailscaleDiscoverer) Discover(ctx context.Context, filter Filter) ([]Instance, error) {
+	return nil, fmt.Errorf("tailscale discovery backend (tailnet %q) is not yet implemented", d.tailnet)
+}
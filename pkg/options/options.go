@@ -0,0 +1,58 @@
+// Package options implements restic-style "-o key=value" extended options,
+// namespaced per backend (e.g. "ec2.profile").
+package options
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options is a flat set of "-o key=value" pairs.
+type Options map[string]string
+
+// Get returns the value for key, or def if it isn't set.
+func (o Options) Get(key, def string) string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Namespace returns the subset of Options whose keys are prefixed
+// "prefix.", with the prefix stripped, e.g. Namespace("ec2") turns
+// {"ec2.profile": "prod"} into {"profile": "prod"}.
+func (o Options) Namespace(prefix string) Options {
+	ns := make(Options)
+	for k, v := range o {
+		if rest, ok := strings.CutPrefix(k, prefix+"."); ok {
+			ns[rest] = v
+		}
+	}
+	return ns
+}
+
+// Flag adapts Options to flag.Value so repeated "-o key=value" flags
+// populate it.
+type Flag struct {
+	Options Options
+}
+
+func (f Flag) String() string {
+	if f.Options == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(f.Options))
+	for k, v := range f.Options {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f Flag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("invalid -o value %q, expected key=value", s)
+	}
+	f.Options[key] = value
+	return nil
+}
@@ -0,0 +1,76 @@
+package options
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptionsNamespace(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		prefix string
+		want   Options
+	}{
+		{
+			name:   "strips matching prefix",
+			opts:   Options{"ec2.profile": "prod", "ec2.region": "us-east-1"},
+			prefix: "ec2",
+			want:   Options{"profile": "prod", "region": "us-east-1"},
+		},
+		{
+			name:   "ignores keys under other prefixes",
+			opts:   Options{"ec2.profile": "prod", "static.file": "hosts.yaml"},
+			prefix: "ec2",
+			want:   Options{"profile": "prod"},
+		},
+		{
+			name:   "does not match prefix without a dot separator",
+			opts:   Options{"ec2profile": "prod"},
+			prefix: "ec2",
+			want:   Options{},
+		},
+		{
+			name:   "empty options yields empty namespace",
+			opts:   Options{},
+			prefix: "ec2",
+			want:   Options{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.opts.Namespace(tt.prefix)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Namespace(%q) = %#v, want %#v", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionsGet(t *testing.T) {
+	opts := Options{"profile": "prod"}
+
+	if got := opts.Get("profile", "default"); got != "prod" {
+		t.Errorf("Get(profile) = %q, want %q", got, "prod")
+	}
+	if got := opts.Get("region", "us-east-1"); got != "us-east-1" {
+		t.Errorf("Get(region) = %q, want default %q", got, "us-east-1")
+	}
+}
+
+func TestFlagSet(t *testing.T) {
+	opts := make(Options)
+	f := Flag{Options: opts}
+
+	if err := f.Set("ec2.profile=prod"); err != nil {
+		t.Fatalf("Set returned error: %v", err)
+	}
+	if opts["ec2.profile"] != "prod" {
+		t.Errorf("opts[ec2.profile] = %q, want %q", opts["ec2.profile"], "prod")
+	}
+
+	if err := f.Set("no-equals-sign"); err == nil {
+		t.Error("Set(\"no-equals-sign\") returned nil error, want an error")
+	}
+}
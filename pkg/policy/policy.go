@@ -0,0 +1,175 @@
+// Package policy implements a small, Tailscale-SSH-rules-style access
+// control engine for deciding which discovered instances get emitted into
+// the generated SSH config, as which remote user, and via which bastion.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Instance is the subset of a discovered host's attributes a Policy
+// evaluates rules against.
+type Instance struct {
+	Name   string
+	Region string
+	VPCID  string
+	Tags   map[string]string
+}
+
+// Match selects instances by tag, region, or VPC. An empty field matches
+// anything.
+type Match struct {
+	Env    string            `json:"env,omitempty" yaml:"env,omitempty"`
+	Role   string            `json:"role,omitempty" yaml:"role,omitempty"`
+	VPCID  string            `json:"vpc_id,omitempty" yaml:"vpc_id,omitempty"`
+	Region string            `json:"region,omitempty" yaml:"region,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// Rule is one entry in a Policy. Action is one of "allow", "deny", or
+// "jump-through:<bastion-selector>".
+type Rule struct {
+	Principals []string          `json:"principals" yaml:"principals"`
+	Match      Match             `json:"match" yaml:"match"`
+	Expires    *time.Time        `json:"expires,omitempty" yaml:"expires,omitempty"`
+	Action     string            `json:"action" yaml:"action"`
+	SSHUsers   map[string]string `json:"ssh_users,omitempty" yaml:"ssh_users,omitempty"`
+}
+
+// Policy is an ordered list of Rules. Evaluation walks them in order; the
+// first matching, unexpired rule wins.
+type Policy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Decision is the outcome of evaluating a Policy against an Instance for a
+// given local principal.
+type Decision struct {
+	Allow           bool
+	User            string // remote SSH user to connect as
+	BastionSelector string // non-empty when Action was "jump-through:<selector>"
+}
+
+// Load reads a Policy from a YAML or JSON file, chosen by extension
+// (".json" is parsed as JSON, everything else as YAML).
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(data, &p)
+	} else {
+		err = yaml.Unmarshal(data, &p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Default builds the Policy equivalent to aws-ssh's previous hardcoded
+// behavior: deny each role in rolesToSkip, then allow everyone else through
+// the bastion as ec2-user.
+func Default(rolesToSkip []string) *Policy {
+	var rules []Rule
+	for _, role := range rolesToSkip {
+		if role = strings.TrimSpace(role); role != "" {
+			rules = append(rules, Rule{
+				Principals: []string{"*"},
+				Match:      Match{Role: role},
+				Action:     "deny",
+			})
+		}
+	}
+	rules = append(rules, Rule{
+		Principals: []string{"*"},
+		Action:     "jump-through:bastion",
+		SSHUsers:   map[string]string{"*": "ec2-user"},
+	})
+	return &Policy{Rules: rules}
+}
+
+// Evaluate walks rules in order and returns the Decision for the first
+// matching, unexpired rule. An instance matched by no rule is denied.
+func (p *Policy) Evaluate(principal string, inst Instance) Decision {
+	for i, rule := range p.Rules {
+		if rule.Expires != nil && rule.Expires.Before(time.Now()) {
+			log.Printf("policy: rule %d expired %s, skipping", i, rule.Expires.Format(time.RFC3339))
+			continue
+		}
+		if !rule.matchesPrincipal(principal) || !rule.Match.matches(inst) {
+			continue
+		}
+
+		switch {
+		case rule.Action == "deny":
+			return Decision{Allow: false}
+		case rule.Action == "allow":
+			return Decision{Allow: true, User: rule.sshUserFor(principal)}
+		case strings.HasPrefix(rule.Action, "jump-through:"):
+			return Decision{
+				Allow:           true,
+				User:            rule.sshUserFor(principal),
+				BastionSelector: strings.TrimPrefix(rule.Action, "jump-through:"),
+			}
+		default:
+			log.Printf("policy: rule %d has unknown action %q, treating as deny", i, rule.Action)
+			return Decision{Allow: false}
+		}
+	}
+	return Decision{Allow: false}
+}
+
+func (r Rule) matchesPrincipal(principal string) bool {
+	if len(r.Principals) == 0 {
+		return true
+	}
+	for _, p := range r.Principals {
+		if p == "*" || p == principal {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) sshUserFor(principal string) string {
+	if user, ok := r.SSHUsers[principal]; ok {
+		return user
+	}
+	if user, ok := r.SSHUsers["*"]; ok {
+		return user
+	}
+	return principal
+}
+
+func (m Match) matches(inst Instance) bool {
+	if m.Env != "" && inst.Tags["env"] != m.Env {
+		return false
+	}
+	if m.Role != "" && inst.Tags["role"] != m.Role {
+		return false
+	}
+	if m.VPCID != "" && inst.VPCID != m.VPCID {
+		return false
+	}
+	if m.Region != "" && inst.Region != m.Region {
+		return false
+	}
+	for k, v := range m.Tags {
+		if inst.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
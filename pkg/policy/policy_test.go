@@ -0,0 +1,162 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		rules     []Rule
+		principal string
+		inst      Instance
+		want      Decision
+	}{
+		{
+			name: "first matching rule wins",
+			rules: []Rule{
+				{Principals: []string{"*"}, Match: Match{Role: "db"}, Action: "deny"},
+				{Principals: []string{"*"}, Action: "allow"},
+			},
+			principal: "alice",
+			inst:      Instance{Tags: map[string]string{"role": "db"}},
+			want:      Decision{Allow: false},
+		},
+		{
+			name: "falls through to later rule when earlier doesn't match",
+			rules: []Rule{
+				{Principals: []string{"*"}, Match: Match{Role: "db"}, Action: "deny"},
+				{Principals: []string{"*"}, Action: "allow", SSHUsers: map[string]string{"*": "ec2-user"}},
+			},
+			principal: "alice",
+			inst:      Instance{Tags: map[string]string{"role": "web"}},
+			want:      Decision{Allow: true, User: "ec2-user"},
+		},
+		{
+			name: "no matching rule denies",
+			rules: []Rule{
+				{Principals: []string{"bob"}, Action: "allow"},
+			},
+			principal: "alice",
+			inst:      Instance{},
+			want:      Decision{Allow: false},
+		},
+		{
+			name: "expired rule is skipped",
+			rules: []Rule{
+				{Principals: []string{"*"}, Action: "deny", Expires: &past},
+				{Principals: []string{"*"}, Action: "allow"},
+			},
+			principal: "alice",
+			inst:      Instance{},
+			want:      Decision{Allow: true, User: "alice"},
+		},
+		{
+			name: "unexpired rule with a future expiry still applies",
+			rules: []Rule{
+				{Principals: []string{"*"}, Action: "deny", Expires: &future},
+			},
+			principal: "alice",
+			inst:      Instance{},
+			want:      Decision{Allow: false},
+		},
+		{
+			name: "jump-through carries its selector",
+			rules: []Rule{
+				{Principals: []string{"*"}, Action: "jump-through:bastion-eu"},
+			},
+			principal: "alice",
+			inst:      Instance{},
+			want:      Decision{Allow: true, User: "alice", BastionSelector: "bastion-eu"},
+		},
+		{
+			name: "ssh_users falls back to the principal's own name",
+			rules: []Rule{
+				{Principals: []string{"*"}, Action: "allow", SSHUsers: map[string]string{"bob": "root"}},
+			},
+			principal: "alice",
+			inst:      Instance{},
+			want:      Decision{Allow: true, User: "alice"},
+		},
+		{
+			name: "ssh_users prefers an exact principal match over the wildcard",
+			rules: []Rule{
+				{Principals: []string{"*"}, Action: "allow", SSHUsers: map[string]string{"*": "ec2-user", "alice": "alice-admin"}},
+			},
+			principal: "alice",
+			inst:      Instance{},
+			want:      Decision{Allow: true, User: "alice-admin"},
+		},
+		{
+			name: "match requires env, role, vpc_id, region, and tags to all agree",
+			rules: []Rule{
+				{
+					Principals: []string{"*"},
+					Match: Match{
+						Env:    "prod",
+						Role:   "web",
+						VPCID:  "vpc-123",
+						Region: "us-east-1",
+						Tags:   map[string]string{"team": "infra"},
+					},
+					Action: "allow",
+				},
+			},
+			principal: "alice",
+			inst: Instance{
+				Region: "us-east-1",
+				VPCID:  "vpc-123",
+				Tags:   map[string]string{"env": "prod", "role": "web", "team": "infra"},
+			},
+			want: Decision{Allow: true, User: "alice"},
+		},
+		{
+			name: "a single mismatched selector excludes the rule",
+			rules: []Rule{
+				{Principals: []string{"*"}, Match: Match{VPCID: "vpc-123"}, Action: "allow"},
+			},
+			principal: "alice",
+			inst:      Instance{VPCID: "vpc-456"},
+			want:      Decision{Allow: false},
+		},
+		{
+			name: "principal must be listed unless the rule is wildcarded",
+			rules: []Rule{
+				{Principals: []string{"bob"}, Action: "allow"},
+				{Principals: []string{"*"}, Action: "deny"},
+			},
+			principal: "alice",
+			inst:      Instance{},
+			want:      Decision{Allow: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{Rules: tt.rules}
+			got := p.Evaluate(tt.principal, tt.inst)
+			if got != tt.want {
+				t.Errorf("Evaluate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	p := Default([]string{"nat", " ", "bastion"})
+
+	denyNat := p.Evaluate("alice", Instance{Tags: map[string]string{"role": "nat"}})
+	if denyNat.Allow {
+		t.Errorf("Default policy allowed a skipped role: %+v", denyNat)
+	}
+
+	allowed := p.Evaluate("alice", Instance{Tags: map[string]string{"role": "web"}})
+	want := Decision{Allow: true, User: "ec2-user", BastionSelector: "bastion"}
+	if allowed != want {
+		t.Errorf("Default policy for an unskipped role = %+v, want %+v", allowed, want)
+	}
+}
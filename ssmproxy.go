@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+
+	"github.com/paulsmith/aws-ssh/pkg/discover"
+)
+
+// filterSSMOnline keeps only the instances whose SSM agent currently
+// reports a ping status of Online, so -proxy=ssm never emits a Host block
+// for an instance that can't actually be reached through Session Manager.
+// regions may be a comma-delimited list, matching -region.
+func filterSSMOnline(regions string, instances []discover.Instance) ([]discover.Instance, error) {
+	online := make(map[string]bool)
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("ssm: creating session: %w", err)
+	}
+
+	for _, region := range strings.Split(regions, ",") {
+		if region = strings.TrimSpace(region); region == "" {
+			continue
+		}
+
+		svc := ssm.New(sess, aws.NewConfig().WithRegion(region))
+
+		input := &ssm.DescribeInstanceInformationInput{}
+		for {
+			out, err := svc.DescribeInstanceInformation(input)
+			if err != nil {
+				return nil, fmt.Errorf("ssm: describing instance information in %s: %w", region, err)
+			}
+			for _, info := range out.InstanceInformationList {
+				if aws.StringValue(info.PingStatus) == ssm.PingStatusOnline {
+					online[aws.StringValue(info.InstanceId)] = true
+				}
+			}
+			if out.NextToken == nil || *out.NextToken == "" {
+				break
+			}
+			input.NextToken = out.NextToken
+		}
+	}
+
+	var filtered []discover.Instance
+	for _, inst := range instances {
+		if online[inst.ID] {
+			filtered = append(filtered, inst)
+		}
+	}
+	return filtered, nil
+}